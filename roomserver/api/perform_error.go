@@ -0,0 +1,27 @@
+package api
+
+import "fmt"
+
+// PerformErrorCode identifies why a Perform* call failed, so that callers
+// (typically the client API) can map it onto the right user-facing Matrix
+// error without having to pattern-match error strings.
+type PerformErrorCode int
+
+const (
+	// PerformErrorNotAllowed means the request was understood but rejected
+	// - for example a membership change vetoed by a MembershipPolicy hook.
+	// Callers typically map this onto M_FORBIDDEN.
+	PerformErrorNotAllowed PerformErrorCode = iota + 1
+)
+
+// PerformError is returned by RoomserverInternalAPI Perform* methods when a
+// request is rejected rather than failing outright, carrying enough
+// information for a caller to build the right user-facing response.
+type PerformError struct {
+	Code PerformErrorCode
+	Msg  string
+}
+
+func (e *PerformError) Error() string {
+	return fmt.Sprintf("roomserver: %s", e.Msg)
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/getsentry/sentry-go"
 	asAPI "github.com/matrix-org/dendrite/appservice/api"
@@ -42,14 +43,35 @@ type RoomserverInternalAPI struct {
 	InputRoomEventTopic    string // JetStream topic for new input room events
 	OutputRoomEventTopic   string // JetStream topic for new output room events
 	PerspectiveServerNames []gomatrixserverlib.ServerName
+	membershipPolicies     []perform.MembershipPolicy
 }
 
+// SetMembershipPolicies registers the chain of MembershipPolicy hooks that
+// PerformInvite and PerformLeave will consult before authoring their events.
+// Hooks run in the order given; the first one to reject a request wins.
+// Calling this again replaces the previously registered chain.
+func (r *RoomserverInternalAPI) SetMembershipPolicies(policies []perform.MembershipPolicy) {
+	r.membershipPolicies = policies
+}
+
+// NewRoomserverAPI creates a new RoomserverInternalAPI. inputCfg carries the
+// topic names and per-topic OutputSink overrides that used to be individual
+// parameters here; callers populating it from config.RoomServer should do so
+// the same way they already resolve consumer (the JetStream context) from
+// config before calling this. Any output topic with no entry in
+// inputCfg.OutputSinksByTopic falls back to a JetStreamOutputSink wrapping
+// consumer, preserving dendrite's default behaviour.
+//
+// NB: this replaces the separate inputRoomEventTopic and outputRoomEventTopic
+// parameters of the previous revision of this constructor - external call
+// sites need to move to passing input.Config{}.
 func NewRoomserverAPI(
 	cfg *config.RoomServer, roomserverDB storage.Database, consumer nats.JetStreamContext,
-	inputRoomEventTopic, outputRoomEventTopic string, caches caching.RoomServerCaches,
+	inputCfg input.Config, caches caching.RoomServerCaches,
 	perspectiveServerNames []gomatrixserverlib.ServerName,
 ) *RoomserverInternalAPI {
 	serverACLs := acls.NewServerACLs(roomserverDB)
+	defaultOutputSink := &input.JetStreamOutputSink{JetStream: consumer}
 	a := &RoomserverInternalAPI{
 		DB:                     roomserverDB,
 		Cfg:                    cfg,
@@ -64,9 +86,13 @@ func NewRoomserverAPI(
 		},
 		Inputer: &input.Inputer{
 			DB:                   roomserverDB,
-			InputRoomEventTopic:  inputRoomEventTopic,
-			OutputRoomEventTopic: outputRoomEventTopic,
+			InputRoomEventTopic:  inputCfg.InputRoomEventTopic,
+			OutputRoomEventTopic: inputCfg.OutputRoomEventTopic,
 			JetStream:            consumer,
+			OutputSink:           defaultOutputSink,
+			OutputSinks:          inputCfg.OutputSinksByTopic,
+			NumWorkers:           inputCfg.NumWorkers,
+			ShardQueueSize:       inputCfg.ShardQueueSize,
 			Durable:              cfg.Matrix.JetStream.Durable("RoomserverInputConsumer"),
 			ServerName:           cfg.Matrix.ServerName,
 			ACLs:                 serverACLs,
@@ -153,6 +179,22 @@ func (r *RoomserverInternalAPI) PerformInvite(
 	req *api.PerformInviteRequest,
 	res *api.PerformInviteResponse,
 ) error {
+	unsigned := map[string]interface{}{}
+	reject, reason, err := perform.RunInviteMembershipPolicies(ctx, r.membershipPolicies, req, unsigned)
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+	if reject {
+		return &api.PerformError{Code: api.PerformErrorNotAllowed, Msg: fmt.Sprintf("invite rejected by membership policy: %s", reason)}
+	}
+	if len(unsigned) > 0 {
+		ctx = perform.WithMembershipPolicyUnsigned(ctx, unsigned)
+		if err = perform.ApplyMembershipPolicyUnsigned(ctx, req.Event); err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
+	}
 	outputEvents, err := r.Inviter.PerformInvite(ctx, req, res)
 	if err != nil {
 		sentry.CaptureException(err)
@@ -169,6 +211,18 @@ func (r *RoomserverInternalAPI) PerformLeave(
 	req *api.PerformLeaveRequest,
 	res *api.PerformLeaveResponse,
 ) error {
+	unsigned := map[string]interface{}{}
+	reject, reason, err := perform.RunLeaveMembershipPolicies(ctx, r.membershipPolicies, req, unsigned)
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+	if reject {
+		return &api.PerformError{Code: api.PerformErrorNotAllowed, Msg: fmt.Sprintf("leave rejected by membership policy: %s", reason)}
+	}
+	if len(unsigned) > 0 {
+		ctx = perform.WithMembershipPolicyUnsigned(ctx, unsigned)
+	}
 	outputEvents, err := r.Leaver.PerformLeave(ctx, req, res)
 	if err != nil {
 		sentry.CaptureException(err)
@@ -0,0 +1,29 @@
+package input
+
+// Config bundles the topic and output-sink wiring an Inputer needs. It
+// exists so that NewRoomserverAPI's signature does not have to keep
+// changing every time a new knob is added here - add a field to Config
+// instead and have the caller populate it from config.RoomServer.
+type Config struct {
+	// InputRoomEventTopic is the JetStream topic new input room events are
+	// consumed from.
+	InputRoomEventTopic string
+	// OutputRoomEventTopic is the JetStream topic output room events are
+	// produced to by default.
+	OutputRoomEventTopic string
+	// OutputSinksByTopic optionally overrides the default JetStream
+	// OutputSink on a per-topic basis, so that configuration can route
+	// individual output topics to a different backend (for example, Kafka)
+	// while everything else stays on JetStream. Populated by the caller
+	// from config.RoomServer, the same way the topic names above are.
+	// A topic with no entry falls back to the default JetStreamOutputSink.
+	OutputSinksByTopic map[string]OutputSink
+	// NumWorkers is how many per-room shard workers process input room
+	// events concurrently; see Inputer.NumWorkers. Zero uses
+	// DefaultNumWorkers, letting operators leave it unset.
+	NumWorkers int
+	// ShardQueueSize is how many input room events a single shard worker
+	// buffers before backpressure kicks in; see Inputer.ShardQueueSize.
+	// Zero uses DefaultShardQueueSize.
+	ShardQueueSize int
+}
@@ -0,0 +1,176 @@
+// Package input is responsible for accepting new room events from the rest
+// of dendrite (the client API, the federation API, appservices, ...),
+// persisting them and notifying downstream components of the result.
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/dendrite/roomserver/acls"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// processRetryDelay is how long we ask NATS to wait before redelivering an
+// input room event that failed to process because of a transient error
+// (e.g. a database hiccup), rather than a problem with the event itself.
+const processRetryDelay = 5 * time.Second
+
+// Inputer is responsible for consuming new input room events from the input
+// topic and producing the resulting output room events onto the output
+// topic. The input topic is consumed from JetStream; how the output topic is
+// actually implemented is delegated to an OutputSink, so that output is not
+// hardcoded to a single message bus - see OutputSink for the available
+// implementations.
+type Inputer struct {
+	DB                   storage.Database
+	ServerName           gomatrixserverlib.ServerName
+	ACLs                 *acls.ServerACLs
+	JetStream            nats.JetStreamContext
+	Durable              nats.SubOpt
+	InputRoomEventTopic  string
+	OutputRoomEventTopic string
+	// OutputSink is the default OutputSink used for OutputRoomEventTopic
+	// when OutputSinks has no entry for it. If nil, a JetStreamOutputSink
+	// wrapping JetStream is used, which preserves dendrite's historical
+	// behaviour.
+	OutputSink OutputSink
+	// OutputSinks optionally overrides OutputSink on a per-topic basis, so
+	// that configuration can route different output topics to different
+	// backends (for example, keeping most topics on JetStream while sending
+	// one to Kafka for an external consumer). Populated by the caller from
+	// config, the same way JetStream itself is already resolved from config
+	// before being passed in here.
+	OutputSinks map[string]OutputSink
+	// NumWorkers is how many shard workers process input room events
+	// concurrently. Events for the same room always land on the same
+	// worker, so per-room ordering is preserved while unrelated rooms are
+	// processed in parallel. Defaults to DefaultNumWorkers.
+	NumWorkers int
+	// ShardQueueSize is how many input room events a single shard worker
+	// will buffer before the Inputer starts applying backpressure. Defaults
+	// to DefaultShardQueueSize.
+	ShardQueueSize int
+
+	shards []*shard
+}
+
+// Start starts consuming from the input room events topic, dispatching
+// messages to a pool of per-room shard workers (see shardFor) so that
+// ingestion for unrelated rooms is not serialised behind a single consumer.
+func (r *Inputer) Start() error {
+	if r.OutputSink == nil {
+		r.OutputSink = &JetStreamOutputSink{JetStream: r.JetStream}
+	}
+	r.startShardWorkers()
+	_, err := r.JetStream.Subscribe(
+		r.InputRoomEventTopic,
+		r.onMessage,
+		r.Durable,
+		nats.ManualAck(),
+	)
+	return err
+}
+
+// onMessage is called by the JetStream subscription for each message
+// received on the input room events topic. It only extracts the room ID and
+// hands the message off to the appropriate shard worker; the shard worker is
+// what actually acks/nacks the message once it has been processed.
+func (r *Inputer) onMessage(msg *nats.Msg) {
+	roomID := msg.Header.Get("room_id")
+	if roomID == "" {
+		var header struct {
+			RoomID string `json:"room_id"`
+		}
+		if err := json.Unmarshal(msg.Data, &header); err != nil {
+			logrus.WithError(err).Error("roomserver input: failed to determine room ID for message, dropping")
+			_ = msg.Term()
+			return
+		}
+		roomID = header.RoomID
+	}
+	r.dispatch(roomID, msg)
+}
+
+// processMessage is run by a shard worker for each message it dequeues. It
+// unmarshals the input room event, rejects it outright if the sending
+// server is ACL'd out of the room, persists it, and produces whatever
+// output room events result, before acking or nacking the underlying NATS
+// message accordingly. The heavier lifting of auth checks and state
+// resolution for the event is delegated to r.DB, consistent with how
+// Queryer and the perform-ers already treat storage.Database as the source
+// of truth rather than duplicating that logic here.
+func (r *Inputer) processMessage(msg *nats.Msg) {
+	var ire api.InputRoomEvent
+	if err := json.Unmarshal(msg.Data, &ire); err != nil {
+		logrus.WithError(err).Error("roomserver input: failed to unmarshal input room event, dropping")
+		_ = msg.Term()
+		return
+	}
+
+	roomID := ire.Event.RoomID()
+	logger := logrus.WithFields(logrus.Fields{
+		"room_id":  roomID,
+		"event_id": ire.Event.EventID(),
+	})
+
+	if r.ACLs != nil && !r.ACLs.IsServerAllowed(ire.Event.Origin(), roomID, ire.Event.Event) {
+		logger.Warn("roomserver input: event rejected by server ACLs, dropping")
+		_ = msg.Term()
+		return
+	}
+
+	outputEvents, err := r.DB.StoreEvent(context.Background(), ire)
+	if err != nil {
+		logger.WithError(err).Error("roomserver input: failed to store event, will retry")
+		_ = msg.NakWithDelay(processRetryDelay)
+		return
+	}
+
+	if err = r.WriteOutputEvents(roomID, outputEvents); err != nil {
+		logger.WithError(err).Error("roomserver input: failed to write output events, will retry")
+		_ = msg.NakWithDelay(processRetryDelay)
+		return
+	}
+
+	_ = msg.Ack()
+}
+
+// outputSinkFor returns the OutputSink that should be used for topic,
+// preferring a per-topic override in OutputSinks over the default
+// OutputSink.
+func (r *Inputer) outputSinkFor(topic string) OutputSink {
+	if sink, ok := r.OutputSinks[topic]; ok {
+		return sink
+	}
+	return r.OutputSink
+}
+
+// WriteOutputEvents writes the given output room events for roomID to
+// OutputRoomEventTopic's configured OutputSink, in order.
+func (r *Inputer) WriteOutputEvents(roomID string, updates []api.OutputEvent) error {
+	sink := r.outputSinkFor(r.OutputRoomEventTopic)
+	if sink == nil {
+		return fmt.Errorf("roomserver input: no output sink configured for topic %q", r.OutputRoomEventTopic)
+	}
+	for _, update := range updates {
+		value, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"room_id": roomID,
+			"type":    update.Type,
+		}).Tracef("Producing to topic '%s'", r.OutputRoomEventTopic)
+		if err = sink.PublishRoomEvent(r.OutputRoomEventTopic, roomID, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
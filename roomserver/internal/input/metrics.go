@@ -0,0 +1,39 @@
+package input
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const pkgNamespace = "dendrite"
+const pkgSubsystem = "roomserver_input"
+
+// roomserverInputQueueDepth reports how many pending input room events are
+// queued for each shard worker, so that an operator can see which shards are
+// falling behind.
+var roomserverInputQueueDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: pkgNamespace,
+		Subsystem: pkgSubsystem,
+		Name:      "queue_depth",
+		Help:      "The number of input room events queued per shard worker.",
+	},
+	[]string{"shard"},
+)
+
+// roomserverInputProcessDuration reports how long it takes a shard worker to
+// process a single input room event, from dequeue to ack/nack.
+var roomserverInputProcessDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: pkgNamespace,
+		Subsystem: pkgSubsystem,
+		Name:      "process_duration_seconds",
+		Help:      "How long it takes a shard worker to process an input room event.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	},
+	[]string{"shard"},
+)
+
+func init() {
+	prometheus.MustRegister(
+		roomserverInputQueueDepth,
+		roomserverInputProcessDuration,
+	)
+}
@@ -0,0 +1,93 @@
+package input
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/nats-io/nats.go"
+)
+
+// OutputSink is implemented by anything capable of delivering a roomserver
+// output room event to whatever is consuming it downstream (the sync API,
+// the federation sender, appservices, etc). It exists so that the roomserver
+// does not have to be compiled against a single hardcoded message bus -
+// operators embedding dendrite, or running it against an existing event bus,
+// can supply their own implementation.
+type OutputSink interface {
+	// PublishRoomEvent delivers payload, the JSON-encoded output room event
+	// for roomID, to topic. Implementations should preserve per-room
+	// ordering of events published to the same topic.
+	PublishRoomEvent(topic, roomID string, payload []byte) error
+}
+
+// JetStreamOutputSink is the default OutputSink, backed by a NATS JetStream
+// stream. This is what dendrite uses out of the box.
+type JetStreamOutputSink struct {
+	JetStream nats.JetStreamContext
+}
+
+func (s *JetStreamOutputSink) PublishRoomEvent(topic, roomID string, payload []byte) error {
+	if s.JetStream == nil {
+		return fmt.Errorf("jetstream output sink: no JetStream context configured")
+	}
+	_, err := s.JetStream.PublishMsg(&nats.Msg{
+		Subject: topic,
+		Header: nats.Header{
+			"room_id": []string{roomID},
+		},
+		Data: payload,
+	})
+	return err
+}
+
+// KafkaOutputSink is an OutputSink for operators who run dendrite alongside
+// an existing Kafka deployment rather than NATS JetStream. roomID is used as
+// the partition key so that events for a given room remain ordered.
+type KafkaOutputSink struct {
+	Producer sarama.SyncProducer
+}
+
+func (s *KafkaOutputSink) PublishRoomEvent(topic, roomID string, payload []byte) error {
+	if s.Producer == nil {
+		return fmt.Errorf("kafka output sink: no producer configured")
+	}
+	_, _, err := s.Producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(roomID),
+		Value: sarama.ByteEncoder(payload),
+	})
+	return err
+}
+
+// ChannelOutputSink is an in-process OutputSink, useful for embedding
+// dendrite's roomserver in another Go process without running a message
+// broker at all, or for unit testing components that depend on output
+// events. Events for all topics are delivered on the single Events channel,
+// each tagged with the topic it was published to.
+type ChannelOutputSink struct {
+	Events chan ChannelOutputEvent
+}
+
+// ChannelOutputEvent is a single message delivered by ChannelOutputSink.
+type ChannelOutputEvent struct {
+	Topic   string
+	RoomID  string
+	Payload []byte
+}
+
+// NewChannelOutputSink creates a ChannelOutputSink with a buffered channel
+// of the given size.
+func NewChannelOutputSink(buffer int) *ChannelOutputSink {
+	return &ChannelOutputSink{
+		Events: make(chan ChannelOutputEvent, buffer),
+	}
+}
+
+func (s *ChannelOutputSink) PublishRoomEvent(topic, roomID string, payload []byte) error {
+	select {
+	case s.Events <- ChannelOutputEvent{Topic: topic, RoomID: roomID, Payload: payload}:
+		return nil
+	default:
+		return fmt.Errorf("channel output sink: buffer full for topic %q", topic)
+	}
+}
@@ -0,0 +1,25 @@
+package input
+
+import "testing"
+
+func TestChannelOutputSinkReturnsErrorWhenBufferFull(t *testing.T) {
+	sink := NewChannelOutputSink(1)
+
+	if err := sink.PublishRoomEvent("output.topic", "!room:example.com", []byte("first")); err != nil {
+		t.Fatalf("unexpected error on first publish: %v", err)
+	}
+
+	err := sink.PublishRoomEvent("output.topic", "!room:example.com", []byte("second"))
+	if err == nil {
+		t.Fatal("expected an error when the channel output sink's buffer is full, got nil")
+	}
+
+	select {
+	case ev := <-sink.Events:
+		if ev.RoomID != "!room:example.com" {
+			t.Fatalf("unexpected room ID on delivered event: %q", ev.RoomID)
+		}
+	default:
+		t.Fatal("expected the first published event to be delivered on the channel")
+	}
+}
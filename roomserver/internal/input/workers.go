@@ -0,0 +1,89 @@
+package input
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultNumWorkers is how many shard workers an Inputer starts if NumWorkers
+// is left unset.
+const DefaultNumWorkers = 4
+
+// DefaultShardQueueSize is how many messages a shard worker will buffer
+// before it starts applying backpressure.
+const DefaultShardQueueSize = 256
+
+// NakDelayOnBackpressure is how long we ask NATS to wait before redelivering
+// a message that we rejected because its shard's queue was full.
+const NakDelayOnBackpressure = 2 * time.Second
+
+// shard is a single worker's bounded input queue. Events for a given room
+// always hash to the same shard, so per-room ordering is preserved even
+// though different rooms are processed concurrently.
+type shard struct {
+	id    int
+	queue chan *nats.Msg
+}
+
+// shardFor returns the shard index that roomID must be processed on, using a
+// consistent hash so the same room always lands on the same worker.
+func shardFor(roomID string, numWorkers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(roomID))
+	return int(h.Sum32() % uint32(numWorkers))
+}
+
+// startShardWorkers creates r.NumWorkers shard queues and starts a goroutine
+// to drain each one, reporting queue depth and processing latency as it goes.
+func (r *Inputer) startShardWorkers() {
+	numWorkers := r.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = DefaultNumWorkers
+	}
+	queueSize := r.ShardQueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultShardQueueSize
+	}
+	r.shards = make([]*shard, numWorkers)
+	for i := range r.shards {
+		s := &shard{id: i, queue: make(chan *nats.Msg, queueSize)}
+		r.shards[i] = s
+		go r.shardWorker(s)
+	}
+}
+
+// shardWorker drains a single shard's queue, processing one message at a
+// time so that events for the rooms hashed to this shard are handled in
+// order.
+func (r *Inputer) shardWorker(s *shard) {
+	label := strconv.Itoa(s.id)
+	for msg := range s.queue {
+		roomserverInputQueueDepth.WithLabelValues(label).Set(float64(len(s.queue)))
+		start := time.Now()
+		r.processMessage(msg)
+		roomserverInputProcessDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+		roomserverInputQueueDepth.WithLabelValues(label).Set(float64(len(s.queue)))
+	}
+}
+
+// dispatch routes msg to the shard owning its room, applying backpressure by
+// NACKing with a delay if that shard's queue is full rather than blocking
+// the NATS subscription (and so stalling every other room).
+func (r *Inputer) dispatch(roomID string, msg *nats.Msg) {
+	s := r.shards[shardFor(roomID, len(r.shards))]
+	select {
+	case s.queue <- msg:
+	default:
+		logrus.WithFields(logrus.Fields{
+			"room_id": roomID,
+			"shard":   s.id,
+		}).Warn("roomserver input: shard queue full, applying backpressure")
+		if err := msg.NakWithDelay(NakDelayOnBackpressure); err != nil {
+			logrus.WithError(err).Error("roomserver input: failed to nack backpressured message")
+		}
+	}
+}
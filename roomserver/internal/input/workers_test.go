@@ -0,0 +1,56 @@
+package input
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestShardForIsConsistentPerRoom(t *testing.T) {
+	roomID := "!abc:example.com"
+	want := shardFor(roomID, 8)
+	for i := 0; i < 100; i++ {
+		if got := shardFor(roomID, 8); got != want {
+			t.Fatalf("shardFor(%q, 8) = %d on call %d, want consistent %d", roomID, got, i, want)
+		}
+	}
+}
+
+func TestShardForDistributesAcrossWorkers(t *testing.T) {
+	const numWorkers = 4
+	seen := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		roomID := fmt.Sprintf("!room%d:example.com", i)
+		seen[shardFor(roomID, numWorkers)] = true
+	}
+	if len(seen) != numWorkers {
+		t.Fatalf("expected 1000 distinct rooms to spread across all %d shards, only hit %d", numWorkers, len(seen))
+	}
+}
+
+func TestDispatchAppliesBackpressureWithoutBlocking(t *testing.T) {
+	r := &Inputer{shards: []*shard{{id: 0, queue: make(chan *nats.Msg, 1)}}}
+
+	// Fill the shard's only queue slot.
+	r.dispatch("!full:example.com", &nats.Msg{})
+
+	// The queue is now full; dispatching another message for the same room
+	// must not block waiting for space, even though the nats.Msg below has
+	// no live subscription to NACK against.
+	done := make(chan struct{})
+	go func() {
+		r.dispatch("!full:example.com", &nats.Msg{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked instead of applying backpressure on a full shard queue")
+	}
+
+	if got := len(r.shards[0].queue); got != 1 {
+		t.Fatalf("expected the backpressured message to be dropped from the queue, queue length = %d", got)
+	}
+}
@@ -0,0 +1,104 @@
+package perform
+
+import (
+	"context"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// MembershipPolicy is implemented by plugins that want to inspect, veto or
+// annotate membership changes before the resulting event is sent into a
+// room. It is the supported extension point for things like anti-abuse
+// checks, invite rate-limiting, or room-directory-driven allowlists, without
+// having to fork the roomserver.
+//
+// Hooks are invoked in registration order (see
+// RoomserverInternalAPI.SetMembershipPolicies) and the first hook to reject
+// a request short-circuits the rest of the chain.
+type MembershipPolicy interface {
+	// CheckInvite is called before an invite event is sent. unsigned is the
+	// unsigned content that will be attached to the invite event; hooks may
+	// add keys to it (e.g. to record which policy approved the invite). If
+	// reject is true, the invite is refused and reason is included in the
+	// message of the PerformError returned to the caller.
+	CheckInvite(ctx context.Context, req *api.PerformInviteRequest, unsigned map[string]interface{}) (reject bool, reason string, err error)
+
+	// CheckLeave is called before a leave event is sent, covering both
+	// voluntary leaves and kicks. The return values behave the same as for
+	// CheckInvite. Keys added to unsigned are attached to the context passed
+	// to Leaver.PerformLeave (see WithMembershipPolicyUnsigned);
+	// RoomserverInternalAPI.PerformLeave does not write them into the leave
+	// event itself, as that event is authored inside the Leaver.
+	CheckLeave(ctx context.Context, req *api.PerformLeaveRequest, unsigned map[string]interface{}) (reject bool, reason string, err error)
+}
+
+type unsignedContextKey struct{}
+
+// WithMembershipPolicyUnsigned attaches unsigned key/value pairs contributed
+// by MembershipPolicy hooks to ctx, so that whatever goes on to author the
+// resulting event (the Inviter or Leaver) can merge them into the event's
+// unsigned content.
+func WithMembershipPolicyUnsigned(ctx context.Context, unsigned map[string]interface{}) context.Context {
+	return context.WithValue(ctx, unsignedContextKey{}, unsigned)
+}
+
+// MembershipPolicyUnsigned returns the unsigned content map attached by
+// WithMembershipPolicyUnsigned, or nil if none was attached.
+func MembershipPolicyUnsigned(ctx context.Context) map[string]interface{} {
+	unsigned, _ := ctx.Value(unsignedContextKey{}).(map[string]interface{})
+	return unsigned
+}
+
+// RunInviteMembershipPolicies runs each policy's CheckInvite in registration
+// order, stopping at the first rejection or error. unsigned accumulates any
+// unsigned content contributed by the policies that ran, for the caller to
+// attach to ctx via WithMembershipPolicyUnsigned.
+func RunInviteMembershipPolicies(
+	ctx context.Context,
+	policies []MembershipPolicy,
+	req *api.PerformInviteRequest,
+	unsigned map[string]interface{},
+) (reject bool, reason string, err error) {
+	for _, policy := range policies {
+		if reject, reason, err = policy.CheckInvite(ctx, req, unsigned); reject || err != nil {
+			return reject, reason, err
+		}
+	}
+	return false, "", nil
+}
+
+// RunLeaveMembershipPolicies runs each policy's CheckLeave in registration
+// order, stopping at the first rejection or error. unsigned behaves the
+// same as in RunInviteMembershipPolicies.
+func RunLeaveMembershipPolicies(
+	ctx context.Context,
+	policies []MembershipPolicy,
+	req *api.PerformLeaveRequest,
+	unsigned map[string]interface{},
+) (reject bool, reason string, err error) {
+	for _, policy := range policies {
+		if reject, reason, err = policy.CheckLeave(ctx, req, unsigned); reject || err != nil {
+			return reject, reason, err
+		}
+	}
+	return false, "", nil
+}
+
+// ApplyMembershipPolicyUnsigned merges any unsigned content attached to ctx
+// by a MembershipPolicy hook into event, which must already be built (and,
+// for federation-bound events, signed - unsigned content is not covered by
+// the event hash, so this is safe to do after signing). It is a no-op if no
+// hook contributed anything.
+//
+// RoomserverInternalAPI.PerformInvite calls this on the invite event in the
+// request before handing it to the Inviter. RoomserverInternalAPI.PerformLeave
+// does not call it: for leaves the contribution is only attached to ctx.
+func ApplyMembershipPolicyUnsigned(ctx context.Context, event *gomatrixserverlib.HeaderedEvent) error {
+	for key, value := range MembershipPolicyUnsigned(ctx) {
+		if err := event.SetUnsignedField(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package perform
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+)
+
+type fakeMembershipPolicy struct {
+	name   string
+	reject bool
+	reason string
+	err    error
+	calls  *[]string
+}
+
+func (f *fakeMembershipPolicy) record() {
+	if f.calls != nil {
+		*f.calls = append(*f.calls, f.name)
+	}
+}
+
+func (f *fakeMembershipPolicy) CheckInvite(ctx context.Context, req *api.PerformInviteRequest, unsigned map[string]interface{}) (bool, string, error) {
+	f.record()
+	return f.reject, f.reason, f.err
+}
+
+func (f *fakeMembershipPolicy) CheckLeave(ctx context.Context, req *api.PerformLeaveRequest, unsigned map[string]interface{}) (bool, string, error) {
+	f.record()
+	return f.reject, f.reason, f.err
+}
+
+func equalStringSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRunInviteMembershipPoliciesShortCircuitsOnReject(t *testing.T) {
+	var calls []string
+	policies := []MembershipPolicy{
+		&fakeMembershipPolicy{name: "first", calls: &calls},
+		&fakeMembershipPolicy{name: "second", reject: true, reason: "blocked", calls: &calls},
+		&fakeMembershipPolicy{name: "third", calls: &calls},
+	}
+
+	reject, reason, err := RunInviteMembershipPolicies(context.Background(), policies, nil, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reject {
+		t.Fatal("expected reject=true")
+	}
+	if reason != "blocked" {
+		t.Fatalf("expected reason %q, got %q", "blocked", reason)
+	}
+	if want := []string{"first", "second"}; !equalStringSlices(calls, want) {
+		t.Fatalf("expected policies %v to run (third should be short-circuited), got %v", want, calls)
+	}
+}
+
+func TestRunInviteMembershipPoliciesRunsAllWhenApproved(t *testing.T) {
+	var calls []string
+	policies := []MembershipPolicy{
+		&fakeMembershipPolicy{name: "first", calls: &calls},
+		&fakeMembershipPolicy{name: "second", calls: &calls},
+	}
+
+	reject, _, err := RunInviteMembershipPolicies(context.Background(), policies, nil, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reject {
+		t.Fatal("expected reject=false")
+	}
+	if want := []string{"first", "second"}; !equalStringSlices(calls, want) {
+		t.Fatalf("expected both policies to run, got %v", calls)
+	}
+}
+
+func TestRunInviteMembershipPoliciesStopsOnError(t *testing.T) {
+	var calls []string
+	wantErr := errors.New("policy backend unavailable")
+	policies := []MembershipPolicy{
+		&fakeMembershipPolicy{name: "first", err: wantErr, calls: &calls},
+		&fakeMembershipPolicy{name: "second", calls: &calls},
+	}
+
+	_, _, err := RunInviteMembershipPolicies(context.Background(), policies, nil, map[string]interface{}{})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected error %v, got %v", wantErr, err)
+	}
+	if want := []string{"first"}; !equalStringSlices(calls, want) {
+		t.Fatalf("expected only the erroring policy to run, got %v", calls)
+	}
+}
+
+func TestRunLeaveMembershipPoliciesShortCircuitsOnReject(t *testing.T) {
+	var calls []string
+	policies := []MembershipPolicy{
+		&fakeMembershipPolicy{name: "first", reject: true, reason: "rate limited", calls: &calls},
+		&fakeMembershipPolicy{name: "second", calls: &calls},
+	}
+
+	reject, reason, err := RunLeaveMembershipPolicies(context.Background(), policies, nil, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reject || reason != "rate limited" {
+		t.Fatalf("expected reject=true reason=%q, got reject=%v reason=%q", "rate limited", reject, reason)
+	}
+	if want := []string{"first"}; !equalStringSlices(calls, want) {
+		t.Fatalf("expected only the first policy to run, got %v", calls)
+	}
+}